@@ -40,6 +40,16 @@ const cacheid_APPEX int = 0x3
 
 // ************************************************************
 
+// data4n is the entry layout stored by a Cacher: a result together with up
+// to three node ids (or operator/cache-generation tags) used to detect hash
+// collisions.
+//
+// Single- and two-key caches (replacecache's Replace(n), applycache's
+// Not(n)) leave b at zero instead of getting their own narrower entry type;
+// that is a deliberate small memory tradeoff (one unused int per entry,
+// e.g. ~33% more than replacecache's old dedicated 3-field entry) made in
+// exchange for every operator cache sharing one entry layout and one
+// Cacher implementation.
 type data4n struct {
 	res int
 	a   int
@@ -47,90 +57,99 @@ type data4n struct {
 	c   int
 }
 
-type data4ncache struct {
-	ratio  int
-	opHit  int // entries found in the caches
-	opMiss int // entries not found in the caches
-	table  []data4n
-}
-
-func (bc *data4ncache) init(size, ratio int) {
-	size = bdd_prime_gte(size)
-	bc.table = make([]data4n, size)
-	bc.ratio = ratio
-	bc.reset()
-}
-
-func (bc *data4ncache) resize(size int) {
-	if bc.ratio > 0 {
-		size = bdd_prime_gte(size / bc.ratio)
-		bc.table = make([]data4n, size)
+// ************************************************************
+//
+// opCache is the state shared by every operator cache: a Cacher holding the
+// actual table plus the hit/miss counters reported by String(). Each
+// operator cache embeds opCache and adds whatever extra fields (current
+// operator, current cache generation, ...) it needs to build its cachekey.
+//
+// When the buddy is configured with WithCacheBudget, budget holds this
+// cache's byte share of the total and entrySize the Cacher's own reported
+// EntrySize(); resize then sizes and re-sizes the table from that byte
+// budget instead of from node-table growth. budget is left at zero
+// otherwise, which keeps the historical node-count-driven sizing below.
+type opCache struct {
+	cache     Cacher
+	opHit     int // entries found in the cache
+	opMiss    int // entries not found in the cache
+	budget    int // byte budget for this cache's table, 0 if unset
+	entrySize uintptr
+}
+
+// init builds the underlying Cacher from cfg.resolvePolicy(), which
+// defaults to DirectMapPolicy and layers in CleanDirtyCache if
+// WithCleanDirtyCache was used; NewBuddy forwards whatever CacheOption the
+// caller selected down to cacheinit, which builds cfg and passes it on
+// here.
+//
+// Under a budget, the table size has to come from the policy's own
+// EntrySize(): LFUCache's entries are bigger than DirectMapCache's, and
+// CleanDirtyCache/ShardedCache report the entry size of the policy they
+// wrap, so a single data4n-sized guess would overshoot the budget for any
+// policy with wider entries. A throwaway 1-slot instance is built first
+// just to ask it.
+func (bc *opCache) init(size, ratio int, cfg cacheConfig, weightPct int) {
+	policy := cfg.resolvePolicy()
+	bc.budget = 0
+	if cfg.budget > 0 {
+		bc.budget = cfg.budget * weightPct / 100
+		ratio = 0 // the budget already determines the table size
+		probe := policy(1, ratio)
+		bc.entrySize = probe.EntrySize()
+		size = budgetToSize(bc.budget, bc.entrySize)
 	}
-	bc.reset()
-}
-
-func (bc *data4ncache) reset() {
-	for k := range bc.table {
-		bc.table[k].a = -1
+	bc.cache = policy(size, ratio)
+	if bc.budget == 0 {
+		bc.entrySize = bc.cache.EntrySize()
 	}
 }
 
-// *************************************************************************
-
-// cache3n is used for caching replace operations
-type data3ncache struct {
-	ratio  int
-	opHit  int // entries found in the replace cache
-	opMiss int // entries not found in the replace cache
-	table  []data3n
-}
-
-type data3n struct {
-	res int
-	a   int
-	c   int
-}
-
-func (bc *data3ncache) init(size, ratio int) {
-	size = bdd_prime_gte(size)
-	bc.table = make([]data3n, size)
-	bc.ratio = ratio
-	bc.reset()
+// resize retargets the table to about nodeHint entries, unless this cache
+// is budget-bound: then the table is only touched if it no longer fits its
+// byte budget, rather than mechanically tracking node-table growth.
+func (bc *opCache) resize(nodeHint int) {
+	if bc.budget > 0 {
+		if bc.cache.Len()*int(bc.entrySize) <= bc.budget {
+			return
+		}
+		bc.cache.Resize(budgetToSize(bc.budget, bc.entrySize))
+		return
+	}
+	bc.cache.Resize(nodeHint)
 }
 
-func (bc *data3ncache) resize(size int) {
-	if bc.ratio > 0 {
-		size = bdd_prime_gte(size / bc.ratio)
-		bc.table = make([]data3n, size)
-	}
-	bc.reset()
+func (bc *opCache) reset() {
+	bc.cache.Reset()
 }
 
-func (bc *data3ncache) reset() {
-	for k := range bc.table {
-		bc.table[k].a = -1
-	}
+func (bc *opCache) len() int {
+	return bc.cache.Len()
 }
 
 // *************************************************************************
 // Setup and shutdown
 
-func (b *buddy) cacheinit(size, ratio int) {
+func (b *buddy) cacheinit(size, ratio int, opts ...CacheOption) {
+	var cfg cacheConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
 	b.quantset = make([]int32, 0)
 	if size <= 0 {
 		size = len(b.nodes)/4 + 1
 	}
 	size = bdd_prime_gte(size)
 	b.applycache = applycache{}
-	b.applycache.init(size, ratio)
+	b.applycache.init(size, ratio, cfg, cacheWeightApply)
 	b.itecache = itecache{}
-	b.itecache.init(size, ratio)
+	b.itecache.init(size, ratio, cfg, cacheWeightIte)
 	b.quantcache = quantcache{}
-	b.quantcache.init(size, ratio)
+	b.quantcache.init(size, ratio, cfg, cacheWeightQuant)
 	b.appexcache = appexcache{}
-	b.appexcache.init(size, ratio)
+	b.appexcache.init(size, ratio, cfg, cacheWeightAppex)
 	b.replacecache = replacecache{}
-	b.replacecache.init(size, ratio)
+	b.replacecache.init(size, ratio, cfg, cacheWeightReplace)
 }
 
 func (b *buddy) cachereset() {
@@ -193,17 +212,17 @@ func (c cacheStat) String() string {
 // The hash function for Apply is #(left, right, applycache.op).
 
 type applycache struct {
-	data4ncache
+	opCache
 	op int // Current operation during an apply
 }
 
 func (bc *applycache) matchapply(left, right int) int {
-	entry := bc.table[_TRIPLE(left, right, bc.op, len(bc.table))]
-	if entry.a == left && entry.b == right && entry.c == bc.op {
+	res, ok := bc.cache.Get(cachekey{a: left, b: right, c: bc.op, shape: shapeApply})
+	if ok {
 		if _DEBUG {
 			bc.opHit++
 		}
-		return entry.res
+		return res
 	}
 	if _DEBUG {
 		bc.opMiss++
@@ -212,24 +231,19 @@ func (bc *applycache) matchapply(left, right int) int {
 }
 
 func (bc *applycache) setapply(left, right, res int) int {
-	bc.table[_TRIPLE(left, right, bc.op, len(bc.table))] = data4n{
-		a:   left,
-		b:   right,
-		c:   bc.op,
-		res: res,
-	}
+	bc.cache.Set(cachekey{a: left, b: right, c: bc.op, shape: shapeApply}, res)
 	return res
 }
 
 // The hash function for operation Not(n) is simply n.
 
 func (bc *applycache) matchnot(n int) int {
-	entry := bc.table[n%len(bc.table)]
-	if entry.a == n && entry.c == int(op_not) {
+	res, ok := bc.cache.Get(cachekey{a: n, c: int(op_not), shape: shapeNot})
+	if ok {
 		if _DEBUG {
 			bc.opHit++
 		}
-		return entry.res
+		return res
 	}
 	if _DEBUG {
 		bc.opMiss++
@@ -238,16 +252,12 @@ func (bc *applycache) matchnot(n int) int {
 }
 
 func (bc *applycache) setnot(n, res int) int {
-	bc.table[n%len(bc.table)] = data4n{
-		a:   n,
-		c:   int(op_not),
-		res: res,
-	}
+	bc.cache.Set(cachekey{a: n, c: int(op_not), shape: shapeNot}, res)
 	return res
 }
 
 func (bc applycache) String() string {
-	res := fmt.Sprintf("== Apply cache  cache (%s)\n", humanSize(len(bc.table), unsafe.Sizeof(data4n{})))
+	res := fmt.Sprintf("== Apply cache  cache (%s)\n", humanSize(bc.len(), unsafe.Sizeof(data4n{})))
 	res += fmt.Sprintf(" Operator Hits: %d\n", bc.opHit)
 	res += fmt.Sprintf(" Operator Miss: %d\n", bc.opMiss)
 	return res
@@ -258,16 +268,16 @@ func (bc applycache) String() string {
 // per entry.
 
 type itecache struct {
-	data4ncache
+	opCache
 }
 
 func (bc *itecache) matchite(f, g, h int) int {
-	entry := bc.table[_TRIPLE(f, g, h, len(bc.table))]
-	if entry.a == f && entry.b == g && entry.c == h {
+	res, ok := bc.cache.Get(cachekey{a: f, b: g, c: h, shape: shapeIte})
+	if ok {
 		if _DEBUG {
 			bc.opHit++
 		}
-		return entry.res
+		return res
 	}
 	if _DEBUG {
 		bc.opMiss++
@@ -276,17 +286,12 @@ func (bc *itecache) matchite(f, g, h int) int {
 }
 
 func (bc *itecache) setite(f, g, h, res int) int {
-	bc.table[_TRIPLE(f, g, h, len(bc.table))] = data4n{
-		a:   f,
-		b:   g,
-		c:   h,
-		res: res,
-	}
+	bc.cache.Set(cachekey{a: f, b: g, c: h, shape: shapeIte}, res)
 	return res
 }
 
 func (bc itecache) String() string {
-	res := fmt.Sprintf("== ITE cache  cache (%s)\n", humanSize(len(bc.table), unsafe.Sizeof(data4n{})))
+	res := fmt.Sprintf("== ITE cache  cache (%s)\n", humanSize(bc.len(), unsafe.Sizeof(data4n{})))
 	res += fmt.Sprintf(" Operator Hits: %d\n", bc.opHit)
 	res += fmt.Sprintf(" Operator Miss: %d\n", bc.opMiss)
 	return res
@@ -296,17 +301,17 @@ func (bc itecache) String() string {
 // The hash function for quantification is (n, varset, quantid).
 
 type quantcache struct {
-	data4ncache     // Cache for exist/forall results
-	id          int // Current cache id for quantifications
+	opCache     // Cache for exist/forall results
+	id      int // Current cache id for quantifications
 }
 
 func (bc *quantcache) matchquant(n, varset int) int {
-	entry := bc.table[_PAIR(n, varset, len(bc.table))]
-	if entry.a == n && entry.b == varset && entry.c == bc.id {
+	res, ok := bc.cache.Get(cachekey{a: n, b: varset, c: bc.id, shape: shapeQuant})
+	if ok {
 		if _DEBUG {
 			bc.opHit++
 		}
-		return entry.res
+		return res
 	}
 	if _DEBUG {
 		bc.opMiss++
@@ -315,17 +320,12 @@ func (bc *quantcache) matchquant(n, varset int) int {
 }
 
 func (bc *quantcache) setquant(n, varset, res int) int {
-	bc.table[_PAIR(n, varset, len(bc.table))] = data4n{
-		a:   n,
-		b:   varset,
-		c:   bc.id,
-		res: res,
-	}
+	bc.cache.Set(cachekey{a: n, b: varset, c: bc.id, shape: shapeQuant}, res)
 	return res
 }
 
 func (bc quantcache) String() string {
-	res := fmt.Sprintf("== Quant cache  cache (%s)\n", humanSize(len(bc.table), unsafe.Sizeof(data4n{})))
+	res := fmt.Sprintf("== Quant cache  cache (%s)\n", humanSize(bc.len(), unsafe.Sizeof(data4n{})))
 	res += fmt.Sprintf(" Operator Hits: %d\n", bc.opHit)
 	res += fmt.Sprintf(" Operator Miss: %d\n", bc.opMiss)
 	return res
@@ -337,18 +337,18 @@ func (bc quantcache) String() string {
 
 // appexcache are a mix of  quant and apply caches
 type appexcache struct {
-	data4ncache     // Cache for appex/appall results
-	op          int // Current operator for appex
-	id          int // Current id
+	opCache     // Cache for appex/appall results
+	op      int // Current operator for appex
+	id      int // Current id
 }
 
 func (bc *appexcache) matchappex(left, right int) int {
-	entry := bc.table[_TRIPLE(left, right, bc.id, len(bc.table))]
-	if entry.a == left && entry.b == right && entry.c == bc.id {
+	res, ok := bc.cache.Get(cachekey{a: left, b: right, c: bc.id, shape: shapeAppEx})
+	if ok {
 		if _DEBUG {
 			bc.opHit++
 		}
-		return entry.res
+		return res
 	}
 	if _DEBUG {
 		bc.opMiss++
@@ -357,17 +357,12 @@ func (bc *appexcache) matchappex(left, right int) int {
 }
 
 func (bc *appexcache) setappex(left, right, res int) int {
-	bc.table[_TRIPLE(left, right, bc.id, len(bc.table))] = data4n{
-		a:   left,
-		b:   right,
-		c:   bc.id,
-		res: res,
-	}
+	bc.cache.Set(cachekey{a: left, b: right, c: bc.id, shape: shapeAppEx}, res)
 	return res
 }
 
 func (bc appexcache) String() string {
-	res := fmt.Sprintf("== AppEx cache  cache (%s)\n", humanSize(len(bc.table), unsafe.Sizeof(data4n{})))
+	res := fmt.Sprintf("== AppEx cache  cache (%s)\n", humanSize(bc.len(), unsafe.Sizeof(data4n{})))
 	res += fmt.Sprintf(" Operator Hits: %d\n", bc.opHit)
 	res += fmt.Sprintf(" Operator Miss: %d\n", bc.opMiss)
 	return res
@@ -377,17 +372,17 @@ func (bc appexcache) String() string {
 // The hash function for operation Replace(n) is simply n.
 
 type replacecache struct {
-	data3ncache     // Cache for replace results
-	id          int // Current cache id for replace
+	opCache     // Cache for replace results
+	id      int // Current cache id for replace
 }
 
 func (bc *replacecache) matchreplace(n int) int {
-	entry := bc.table[n%len(bc.table)]
-	if entry.a == n && entry.c == bc.id {
+	res, ok := bc.cache.Get(cachekey{a: n, c: bc.id, shape: shapeReplace})
+	if ok {
 		if _DEBUG {
 			bc.opHit++
 		}
-		return entry.res
+		return res
 	}
 	if _DEBUG {
 		bc.opMiss++
@@ -396,16 +391,12 @@ func (bc *replacecache) matchreplace(n int) int {
 }
 
 func (bc *replacecache) setreplace(n, res int) int {
-	bc.table[n%len(bc.table)] = data3n{
-		a:   n,
-		c:   bc.id,
-		res: res,
-	}
+	bc.cache.Set(cachekey{a: n, c: bc.id, shape: shapeReplace}, res)
 	return res
 }
 
 func (bc replacecache) String() string {
-	res := fmt.Sprintf("== Replace cache  cache (%s)\n", humanSize(len(bc.table), unsafe.Sizeof(data3n{})))
+	res := fmt.Sprintf("== Replace cache  cache (%s)\n", humanSize(bc.len(), unsafe.Sizeof(data4n{})))
 	res += fmt.Sprintf(" Operator Hits: %d\n", bc.opHit)
 	res += fmt.Sprintf(" Operator Miss: %d\n", bc.opMiss)
 	return res
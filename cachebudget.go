@@ -0,0 +1,102 @@
+// Copyright (c) 2021 Silvano DAL ZILIO
+//
+// MIT License
+
+package rudd
+
+// ************************************************************
+//
+// CacheOption configures the five operator caches (applycache, itecache,
+// quantcache, appexcache, replacecache) when a buddy is created with
+// NewBuddy: which Cacher implementation to use (WithCachePolicy), an
+// overall memory budget to size them from instead of node-table growth
+// (WithCacheBudget), and whether to add a structural clean layer that
+// survives GC/rehash (WithCleanDirtyCache).
+type CacheOption func(*cacheConfig)
+
+// cacheConfig collects the CacheOption values applied by cacheinit. policy
+// and structKey are kept separate (rather than having WithCleanDirtyCache
+// immediately wrap policy in a CleanDirtyPolicy) and only composed by
+// resolvePolicy once every option has run, so that WithCachePolicy and
+// WithCleanDirtyCache can be passed to NewBuddy in either order.
+type cacheConfig struct {
+	policy    CachePolicy
+	structKey StructuralKeyFunc
+	budget    int // total byte budget across all five caches, 0 disables it
+}
+
+// resolvePolicy builds the effective CachePolicy for cfg: the selected
+// policy (DirectMapPolicy if none was selected) wrapped in CleanDirtyPolicy
+// if WithCleanDirtyCache was used.
+func (cfg cacheConfig) resolvePolicy() CachePolicy {
+	policy := cfg.policy
+	if policy == nil {
+		policy = DirectMapPolicy
+	}
+	if cfg.structKey != nil {
+		policy = CleanDirtyPolicy(cfg.structKey, policy)
+	}
+	return policy
+}
+
+// WithCachePolicy selects the Cacher implementation built for each operator
+// cache. The default, used when no CacheOption selects one, is
+// DirectMapPolicy.
+func WithCachePolicy(policy CachePolicy) CacheOption {
+	return func(cfg *cacheConfig) { cfg.policy = policy }
+}
+
+// WithCacheBudget bounds the combined size of the five operator caches to
+// about budget bytes, split between them by cacheWeight* below, instead of
+// sizing each cache's table from the node table's length. A cache.resize
+// triggered by node-table growth then leaves the table alone as long as it
+// still fits its byte share.
+func WithCacheBudget(budget int) CacheOption {
+	return func(cfg *cacheConfig) { cfg.budget = budget }
+}
+
+// WithCleanDirtyCache wraps whatever Cacher implementation WithCachePolicy
+// selected (DirectMapPolicy if none did) in a CleanDirtyCache, using
+// structKey to compute the structural key for an argument. See
+// CleanDirtyCache for why this buys large speedups across GC cycles in
+// long-running derivations. Order relative to WithCachePolicy does not
+// matter: the wrapping happens once all CacheOptions have run.
+func WithCleanDirtyCache(structKey StructuralKeyFunc) CacheOption {
+	return func(cfg *cacheConfig) { cfg.structKey = structKey }
+}
+
+// cacheWeight* give each operator cache's share of a CacheBudget, in
+// percent; they must add up to 100. Apply and ITE get the biggest shares
+// since they see the bulk of BDD recursion.
+const (
+	cacheWeightApply   = 30
+	cacheWeightIte     = 20
+	cacheWeightQuant   = 15
+	cacheWeightAppex   = 20
+	cacheWeightReplace = 15
+)
+
+// budgetSizeNumerator/Denominator give the fraction of the byte budget
+// budgetToSize actually asks for; the remainder is headroom. Every Cacher in
+// this package rounds the size it builds its table with up to a prime via
+// bdd_prime_gte (ShardedCache and CleanDirtyCache do this once per
+// shard/layer), so asking
+// for the full budget reliably overshoots it once that rounding is applied.
+// 80% leaves enough slack for the prime gaps seen at the table sizes these
+// caches actually run at.
+const (
+	budgetSizeNumerator   = 4
+	budgetSizeDenominator = 5
+)
+
+// budgetToSize turns a byte budget and an entry size into a table-size
+// hint; the chosen policy still runs this through bdd_prime_gte when it
+// builds its table, which is why this targets a fraction of budget rather
+// than all of it.
+func budgetToSize(budget int, entrySize uintptr) int {
+	n := (budget * budgetSizeNumerator / budgetSizeDenominator) / int(entrySize)
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
@@ -0,0 +1,42 @@
+// Copyright (c) 2021 Silvano DAL ZILIO
+//
+// MIT License
+
+package rudd
+
+import "testing"
+
+// assertWithinBudget builds an opCache under the given policy and budget
+// and checks that the table it ends up with doesn't exceed that budget,
+// using the Cacher's own EntrySize() rather than assuming data4n.
+func assertWithinBudget(t *testing.T, policy CachePolicy, budget int) {
+	t.Helper()
+	var oc opCache
+	cfg := cacheConfig{budget: budget, policy: policy}
+	oc.init(100, 0, cfg, 100)
+	if used := oc.cache.Len() * int(oc.entrySize); used > budget {
+		t.Fatalf("cache used %d bytes, over its %d byte budget", used, budget)
+	}
+}
+
+// Budgets here are large relative to one entry (a few hundred KB) so that
+// bdd_prime_gte's upward rounding - applied once per table, and once per
+// shard/layer for Sharded/CleanDirty - stays a small fraction of the
+// budget; budgetToSize's own headroom (see budgetSizeNumerator) covers the
+// rest.
+const testBudget = 256 * 1024
+
+func TestOpCacheBudgetDirectMapPolicy(t *testing.T) {
+	assertWithinBudget(t, nil, testBudget)
+}
+
+func TestOpCacheBudgetLFUPolicy(t *testing.T) {
+	// LFUCache's entries are wider than data4n's (they also carry a
+	// frequency counter), so a naive data4n-sized estimate would ask for
+	// as many slots as DirectMapCache gets and overshoot the budget.
+	assertWithinBudget(t, LFUPolicy, testBudget)
+}
+
+func TestOpCacheBudgetShardedPolicy(t *testing.T) {
+	assertWithinBudget(t, ShardedPolicy, testBudget)
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2021 Silvano DAL ZILIO
+//
+// MIT License
+
+package rudd
+
+import "unsafe"
+
+// ************************************************************
+//
+// Cacher is the pluggable storage policy behind every operator cache
+// (applycache, itecache, quantcache, appexcache, replacecache). Each
+// operator cache is responsible for turning its arguments into a cachekey;
+// the Cacher decides how (and whether) that key is remembered.
+//
+// The default policy, DirectMapCache, is rudd's historical behavior: a
+// single direct-mapped table where a hash collision simply evicts whatever
+// entry was already there. Alternative policies can be selected with
+// WithCachePolicy, a CacheOption passed to NewBuddy.
+type Cacher interface {
+	// Get looks up key and reports whether a cached result was found.
+	Get(key cachekey) (int, bool)
+	// Set records res under key, possibly evicting another entry.
+	Set(key cachekey, res int)
+	// Reset discards every cached entry.
+	Reset()
+	// Resize retargets the cache to hold about hint entries. Implementations
+	// are free to round hint or ignore it, but should not keep stale
+	// entries from before the resize.
+	Resize(hint int)
+	// Len returns the number of slots backing the cache, for reporting.
+	Len() int
+	// EntrySize returns the number of bytes one slot actually costs under
+	// this implementation, for byte-budget sizing (see WithCacheBudget).
+	EntrySize() uintptr
+}
+
+// keyShape identifies which operator built a cachekey, which is what fixes
+// both the hash formula a Cacher indexes with (see indexFor) and which
+// fields are genuine node ids (see nodeIDFields in cleandirtycache.go).
+type keyShape int
+
+const (
+	shapeApply   keyShape = iota // #(left, right, op): applycache.matchapply
+	shapeNot                     // n alone: applycache.matchnot
+	shapeIte                     // #(f, g, h): itecache.matchite
+	shapeQuant                   // #(n, varset): quantcache.matchquant, id is a tag
+	shapeAppEx                   // #(left, right, id): appexcache.matchappex, id is a tag
+	shapeReplace                 // n alone: replacecache.matchreplace, id is a tag
+)
+
+// cachekey identifies an entry in an operator cache. Every operator cache
+// reduces its lookup to at most three node ids (or a node id and an
+// operator/cache-generation tag); fields that do not apply are left at
+// zero. shape records which operator built the key, so a Cacher can hash it
+// the way that operator always has.
+type cachekey struct {
+	a, b, c int
+	shape   keyShape
+}
+
+// indexFor computes key's table index for a table of the given size, using
+// whichever hash formula key.shape calls for. This is exactly what each
+// operator cache computed inline before Cacher existed: quantcache hashed
+// only (n, varset) via _PAIR, treating its cache-generation id as a
+// post-lookup tag rather than folding it into the hash; Not/Replace hashed
+// the single node id with a plain modulo; everything else hashed all three
+// fields via _TRIPLE. Collision (and so invalidation) behavior depends on
+// this, so a generic _TRIPLE-everywhere index would silently change it.
+func indexFor(key cachekey, size int) int {
+	switch key.shape {
+	case shapeQuant:
+		return _PAIR(key.a, key.b, size)
+	case shapeNot, shapeReplace:
+		return key.a % size
+	default:
+		return _TRIPLE(key.a, key.b, key.c, size)
+	}
+}
+
+// CachePolicy builds the Cacher used by the five operator caches. It is
+// selected through NewBuddy options and defaults to DirectMapPolicy.
+type CachePolicy func(size, ratio int) Cacher
+
+// DirectMapPolicy builds a DirectMapCache, the direct-mapped collision
+// policy rudd has always used.
+func DirectMapPolicy(size, ratio int) Cacher {
+	return newDirectMapCache(size, ratio)
+}
+
+// ************************************************************
+//
+// DirectMapCache is a direct-mapped Cacher: a key hashes to exactly one
+// slot, and storing a new entry there evicts whatever was occupying it.
+
+type DirectMapCache struct {
+	ratio int
+	table []data4n
+}
+
+// newDirectMapCache always allocates the table at the requested size,
+// regardless of ratio; only a later Resize respects the ratio <= 0 "fixed
+// size" gate.
+func newDirectMapCache(size, ratio int) *DirectMapCache {
+	c := &DirectMapCache{ratio: ratio}
+	c.table = make([]data4n, bdd_prime_gte(size))
+	c.Reset()
+	return c
+}
+
+func (c *DirectMapCache) Get(key cachekey) (int, bool) {
+	entry := c.table[indexFor(key, len(c.table))]
+	if entry.a == key.a && entry.b == key.b && entry.c == key.c {
+		return entry.res, true
+	}
+	return -1, false
+}
+
+func (c *DirectMapCache) Set(key cachekey, res int) {
+	c.table[indexFor(key, len(c.table))] = data4n{a: key.a, b: key.b, c: key.c, res: res}
+}
+
+func (c *DirectMapCache) Reset() {
+	for k := range c.table {
+		c.table[k].a = -1
+	}
+}
+
+// Resize only reallocates the table when ratio > 0, matching the baseline
+// data4ncache/data3ncache behavior: a cache built with ratio <= 0 has a
+// fixed-size table that a later cacheresize (e.g. after bdd_gbc) only
+// clears, it never grows or shrinks it to track node-table growth.
+func (c *DirectMapCache) Resize(hint int) {
+	if c.ratio > 0 {
+		c.table = make([]data4n, bdd_prime_gte(hint/c.ratio))
+	}
+	c.Reset()
+}
+
+func (c *DirectMapCache) Len() int {
+	return len(c.table)
+}
+
+func (c *DirectMapCache) EntrySize() uintptr {
+	return unsafe.Sizeof(data4n{})
+}
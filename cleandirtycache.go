@@ -0,0 +1,167 @@
+// Copyright (c) 2021 Silvano DAL ZILIO
+//
+// MIT License
+
+package rudd
+
+// ************************************************************
+//
+// CleanDirtyCache is a two-tier Cacher. The "dirty" layer is a normal,
+// fast, id-keyed cache: it is what every other Cacher in this package
+// already is, and a hit there is returned immediately. The "clean" layer
+// sits behind it, keyed not by node id but by the *structural* identity of
+// each argument (a hash of the sub-BDD the id currently points to). Node
+// ids are only stable until the next bdd_gbc or node-table rehash, at
+// which point they get reused for different sub-BDDs and the dirty layer
+// has to be thrown away; the structural key of a given sub-BDD does not
+// change across a GC or rehash, so the clean layer survives it and a miss
+// in the dirty layer can still be served from there.
+//
+// Computing the structural key of an argument means walking the sub-BDD
+// reachable from it, which needs the node table; that belongs next to the
+// node table itself, where it can be memoized on the node, not here.
+// CleanDirtyCache therefore takes a StructuralKeyFunc hook rather than
+// computing one itself - the caller supplies it through WithCleanDirtyCache,
+// and NewBuddy passes it on unchanged; NewBuddy does not build one from its
+// node table.
+//
+// StructuralKeyFunc is only ever called with a genuine node id, never with
+// an operator code, varset token, or cache-generation tag: a cachekey's
+// fields mean different things depending on which operator cache built it
+// (quantcache's b is a varset token, not a node id; applycache's, quantcache's
+// and replacecache's c are an operator code or generation tag, not a node
+// id), so CleanDirtyCache uses each key's shape (see nodeIDFields) to apply
+// this only to the fields that are actually node ids, and passes the rest
+// through unchanged.
+type StructuralKeyFunc func(id int) int
+
+// CleanDirtyCache implements Cacher.
+type CleanDirtyCache struct {
+	dirty     Cacher
+	clean     Cacher
+	structKey StructuralKeyFunc
+}
+
+// nodeIDFields reports which of a cachekey's a, b, c fields hold genuine
+// BDD node ids for the given shape, as opposed to an operator code, varset
+// token, or cache-generation tag. Hashing a tag through StructuralKeyFunc
+// instead of passing it through unchanged risks a small tag value (e.g. 0
+// or 1) aliasing a real node id and producing a bogus structural key.
+func (s keyShape) nodeIDFields() (a, b, c bool) {
+	switch s {
+	case shapeApply:
+		return true, true, false // left, right are node ids; op is a tag
+	case shapeNot:
+		return true, false, false // n is a node id; op is a tag
+	case shapeIte:
+		return true, true, true // f, g, h are all node ids
+	case shapeQuant:
+		return true, false, false // n is a node id; varset and id are not
+	case shapeAppEx:
+		return true, true, false // left, right are node ids; id is a tag
+	case shapeReplace:
+		return true, false, false // n is a node id; id is a tag
+	}
+	return false, false, false
+}
+
+// structuralKey rebuilds key with every field nodeIDFields reports as a
+// node id replaced by its structural hash, leaving tag fields untouched.
+func (c *CleanDirtyCache) structuralKey(key cachekey) cachekey {
+	aIsNode, bIsNode, cIsNode := key.shape.nodeIDFields()
+	sk := key
+	if aIsNode {
+		sk.a = c.structKey(key.a)
+	}
+	if bIsNode {
+		sk.b = c.structKey(key.b)
+	}
+	if cIsNode {
+		sk.c = c.structKey(key.c)
+	}
+	return sk
+}
+
+// CleanDirtyPolicy builds a CachePolicy that pairs a dirty and a clean
+// layer, both built with layer (DirectMapPolicy if nil). structKey computes
+// the structural key for a node id; a nil structKey disables the clean
+// layer, making this behave exactly like layer alone.
+//
+// size is split evenly between the two layers rather than given to each in
+// full, so that a caller sizing this policy from a byte budget (see
+// WithCacheBudget) gets a table close to that budget instead of twice it.
+func CleanDirtyPolicy(structKey StructuralKeyFunc, layer CachePolicy) CachePolicy {
+	if layer == nil {
+		layer = DirectMapPolicy
+	}
+	return func(size, ratio int) Cacher {
+		half := size / 2
+		if half < 1 {
+			half = 1
+		}
+		return &CleanDirtyCache{
+			dirty:     layer(half, ratio),
+			clean:     layer(half, ratio),
+			structKey: structKey,
+		}
+	}
+}
+
+func (c *CleanDirtyCache) Get(key cachekey) (int, bool) {
+	if res, ok := c.dirty.Get(key); ok {
+		return res, true
+	}
+	if c.structKey == nil {
+		return -1, false
+	}
+	res, ok := c.clean.Get(c.structuralKey(key))
+	if ok {
+		// Promote the hot entry back into the dirty layer under its
+		// current id, so the next lookup takes the fast path again.
+		c.dirty.Set(key, res)
+	}
+	return res, ok
+}
+
+func (c *CleanDirtyCache) Set(key cachekey, res int) {
+	c.dirty.Set(key, res)
+	if c.structKey != nil {
+		c.clean.Set(c.structuralKey(key), res)
+	}
+}
+
+// Reset discards only the dirty layer, since this is what gets called on a
+// cache-invalidating event such as bdd_gbc or a node-table rehash/resize;
+// the clean layer stays around to re-populate the dirty layer lazily as
+// Get calls miss. Use ResetAll to also drop the clean layer.
+func (c *CleanDirtyCache) Reset() {
+	c.dirty.Reset()
+}
+
+// ResetAll discards both layers, e.g. when starting over with an unrelated
+// set of BDDs.
+func (c *CleanDirtyCache) ResetAll() {
+	c.dirty.Reset()
+	c.clean.Reset()
+}
+
+// Resize retargets only the dirty layer to half of hint. The clean layer
+// keeps its size and its entries: structural keys stay valid across the
+// node-table rehash that triggers a resize.
+func (c *CleanDirtyCache) Resize(hint int) {
+	half := hint / 2
+	if half < 1 {
+		half = 1
+	}
+	c.dirty.Resize(half)
+}
+
+func (c *CleanDirtyCache) Len() int {
+	return c.dirty.Len() + c.clean.Len()
+}
+
+// EntrySize reports the dirty layer's per-slot byte cost; both layers are
+// built with the same inner policy, so either is representative.
+func (c *CleanDirtyCache) EntrySize() uintptr {
+	return c.dirty.EntrySize()
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2021 Silvano DAL ZILIO
+//
+// MIT License
+
+package rudd
+
+import "testing"
+
+func identityStructKey(id int) int { return id }
+
+func TestOpCacheBudgetCleanDirtyCache(t *testing.T) {
+	var oc opCache
+	var cfg cacheConfig
+	WithCacheBudget(testBudget)(&cfg)
+	WithCleanDirtyCache(identityStructKey)(&cfg)
+	oc.init(100, 0, cfg, 100)
+	if used := oc.cache.Len() * int(oc.entrySize); used > cfg.budget {
+		t.Fatalf("clean/dirty cache used %d bytes, over its %d byte budget", used, cfg.budget)
+	}
+}
+
+// TestCacheOptionOrderIndependence checks that WithCachePolicy and
+// WithCleanDirtyCache compose the same way regardless of which is passed
+// to NewBuddy first.
+func TestCacheOptionOrderIndependence(t *testing.T) {
+	policyFirst := cacheConfig{}
+	WithCachePolicy(LFUPolicy)(&policyFirst)
+	WithCleanDirtyCache(identityStructKey)(&policyFirst)
+
+	cleanFirst := cacheConfig{}
+	WithCleanDirtyCache(identityStructKey)(&cleanFirst)
+	WithCachePolicy(LFUPolicy)(&cleanFirst)
+
+	a, aok := policyFirst.resolvePolicy()(16, 0).(*CleanDirtyCache)
+	b, bok := cleanFirst.resolvePolicy()(16, 0).(*CleanDirtyCache)
+	if !aok || !bok {
+		t.Fatalf("expected both orderings to produce a CleanDirtyCache, got %v and %v", aok, bok)
+	}
+	if _, ok := a.dirty.(*LFUCache); !ok {
+		t.Fatalf("policy-then-clean ordering lost the LFUPolicy inner layer: %T", a.dirty)
+	}
+	if _, ok := b.dirty.(*LFUCache); !ok {
+		t.Fatalf("clean-then-policy ordering lost the LFUPolicy inner layer: %T", b.dirty)
+	}
+}
+
+// TestCleanDirtyCacheStructuralKeyOnlyHashesNodeIDs checks that
+// structuralKey only runs StructuralKeyFunc over a key's genuine node id
+// fields (per nodeIDFields) and leaves tag fields - quantcache's varset and
+// cache-generation id here - untouched, so a tag value that aliases a real
+// node id (0 in this test) can't be mistaken for that node.
+func TestCleanDirtyCacheStructuralKeyOnlyHashesNodeIDs(t *testing.T) {
+	scramble := func(id int) int { return id*1000 + 7 }
+	c := &CleanDirtyCache{structKey: scramble}
+
+	key := cachekey{a: 5, b: 42, c: 0, shape: shapeQuant}
+	sk := c.structuralKey(key)
+
+	if want := scramble(5); sk.a != want {
+		t.Fatalf("node id field a = %d, want %d (hashed)", sk.a, want)
+	}
+	if sk.b != key.b {
+		t.Fatalf("varset tag field b = %d, want %d (untouched)", sk.b, key.b)
+	}
+	if sk.c != key.c {
+		t.Fatalf("generation tag field c = %d, want %d (untouched)", sk.c, key.c)
+	}
+}
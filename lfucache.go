@@ -0,0 +1,131 @@
+// Copyright (c) 2021 Silvano DAL ZILIO
+//
+// MIT License
+
+package rudd
+
+import "unsafe"
+
+// ************************************************************
+//
+// LFUCache is a set-associative Cacher that keeps a small set of candidate
+// entries per bucket and, on collision, evicts the least-frequently used
+// one instead of unconditionally overwriting the current occupant (as
+// DirectMapCache does). Each entry carries a saturating frequency counter
+// that is incremented on every hit and halved across the whole bucket
+// whenever an insertion finds the bucket full, so that a once-hot entry
+// ages out instead of permanently blocking eviction.
+
+const lfuWays = 4
+const lfuMaxFreq = 15
+
+// data4nLFU is a data4n entry plus its saturating frequency counter.
+type data4nLFU struct {
+	data4n
+	freq uint8
+}
+
+// LFUCache implements Cacher.
+type LFUCache struct {
+	ratio int
+	ways  int
+	table []data4nLFU
+}
+
+// LFUPolicy is the CachePolicy that builds an LFUCache.
+func LFUPolicy(size, ratio int) Cacher {
+	return newLFUCache(size, ratio)
+}
+
+func newLFUCache(size, ratio int) *LFUCache {
+	c := &LFUCache{ratio: ratio, ways: lfuWays}
+	c.Resize(size)
+	return c
+}
+
+func (c *LFUCache) buckets() int {
+	return len(c.table) / c.ways
+}
+
+func (c *LFUCache) bucketStart(key cachekey) int {
+	return indexFor(key, c.buckets()) * c.ways
+}
+
+func (c *LFUCache) Get(key cachekey) (int, bool) {
+	start := c.bucketStart(key)
+	for i := start; i < start+c.ways; i++ {
+		e := &c.table[i]
+		if e.a == key.a && e.b == key.b && e.c == key.c {
+			if e.freq < lfuMaxFreq {
+				e.freq++
+			}
+			return e.res, true
+		}
+	}
+	return -1, false
+}
+
+func (c *LFUCache) Set(key cachekey, res int) {
+	start := c.bucketStart(key)
+	emptyIdx := -1
+	for i := start; i < start+c.ways; i++ {
+		e := &c.table[i]
+		if e.a == key.a && e.b == key.b && e.c == key.c {
+			e.res = res
+			if e.freq < lfuMaxFreq {
+				e.freq++
+			}
+			return
+		}
+		if emptyIdx == -1 && e.a == -1 {
+			emptyIdx = i
+		}
+	}
+	if emptyIdx != -1 {
+		c.table[emptyIdx] = data4nLFU{data4n: data4n{a: key.a, b: key.b, c: key.c, res: res}, freq: 1}
+		return
+	}
+	// Bucket is full: age it so a long-cold entry can lose to a fresher one,
+	// then evict whichever way ends up least-frequently used.
+	lfuIdx := start
+	for i := start; i < start+c.ways; i++ {
+		c.table[i].freq /= 2
+		if c.table[i].freq < c.table[lfuIdx].freq {
+			lfuIdx = i
+		}
+	}
+	c.table[lfuIdx] = data4nLFU{data4n: data4n{a: key.a, b: key.b, c: key.c, res: res}, freq: 1}
+}
+
+func (c *LFUCache) Reset() {
+	for i := range c.table {
+		c.table[i] = data4nLFU{}
+		c.table[i].a = -1
+	}
+}
+
+// Resize sizes the table to about hint total slots (not hint buckets): the
+// bucket count is derived by dividing hint by the number of ways first, so
+// that Len() after a resize is on the same order as hint regardless of
+// lfuWays, matching how every other Cacher interprets its hint.
+func (c *LFUCache) Resize(hint int) {
+	size := hint
+	if c.ratio > 0 {
+		size = hint / c.ratio
+	}
+	size /= c.ways
+	if size < 1 {
+		size = 1
+	}
+	buckets := bdd_prime_gte(size)
+	c.table = make([]data4nLFU, buckets*c.ways)
+	c.Reset()
+}
+
+func (c *LFUCache) Len() int {
+	return len(c.table)
+}
+
+func (c *LFUCache) EntrySize() uintptr {
+	return unsafe.Sizeof(data4nLFU{})
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2021 Silvano DAL ZILIO
+//
+// MIT License
+
+package rudd
+
+import "testing"
+
+// newTestLFUCache builds a single-bucket LFUCache directly, bypassing
+// Resize (and so bdd_prime_gte), so that every key in a test collides into
+// the same bucket regardless of the hash _TRIPLE computes.
+func newTestLFUCache(ways int) *LFUCache {
+	c := &LFUCache{ways: ways, table: make([]data4nLFU, ways)}
+	c.Reset()
+	return c
+}
+
+func TestLFUCacheEvictsLeastFrequentlyUsed(t *testing.T) {
+	c := newTestLFUCache(lfuWays)
+	keys := []cachekey{{a: 1}, {a: 2}, {a: 3}, {a: 4}}
+	for i, k := range keys {
+		c.Set(k, i+100)
+	}
+	hot := keys[0]
+	for i := 0; i < 5; i++ {
+		if _, ok := c.Get(hot); !ok {
+			t.Fatalf("expected %v to still be cached", hot)
+		}
+	}
+	// The bucket is full; inserting one more key must evict someone, but
+	// never the entry we just made the hottest.
+	c.Set(cachekey{a: 5}, 999)
+	if _, ok := c.Get(hot); !ok {
+		t.Fatalf("hot entry %v was evicted even though it had the highest frequency", hot)
+	}
+}
+
+func TestLFUCacheAgesCountersOnEviction(t *testing.T) {
+	c := newTestLFUCache(lfuWays)
+	hot := cachekey{a: 1}
+	c.Set(hot, 1)
+	for i := 0; i < lfuMaxFreq+5; i++ {
+		c.Get(hot)
+	}
+	for i := 2; i <= lfuWays; i++ {
+		c.Set(cachekey{a: i}, i)
+	}
+	// The bucket is now full of one saturated entry and some cold ones;
+	// inserting another key ages every counter in the bucket before
+	// picking an eviction target. The saturated entry should still win.
+	c.Set(cachekey{a: lfuWays + 1}, 42)
+	if got := c.table[0].freq; got != lfuMaxFreq/2 {
+		t.Fatalf("hot entry's counter = %d after inserting into a full bucket, want %d (halved)", got, lfuMaxFreq/2)
+	}
+	if _, ok := c.Get(hot); !ok {
+		t.Fatalf("saturated hot entry %v lost to a freshly-aged cold entry", hot)
+	}
+}
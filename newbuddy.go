@@ -0,0 +1,21 @@
+// Copyright (c) 2021 Silvano DAL ZILIO
+//
+// MIT License
+
+package rudd
+
+// NewBuddy builds a buddy and configures its operator caches from opts (see
+// WithCachePolicy, WithCacheBudget, WithCleanDirtyCache). size and ratio are
+// forwarded to cacheinit exactly as before options existed: size is the
+// initial number of entries of each operator cache (a size <= 0 is replaced
+// by len(nodes)/4+1, computed from the node table, which is still empty in
+// a buddy built here) and ratio relates node-table growth to cache-table
+// growth for caches that are not budget-bound.
+//
+// This is the entry point CacheOption was designed to reach: without it,
+// WithCachePolicy and friends have nothing to configure.
+func NewBuddy(size, ratio int, opts ...CacheOption) *buddy {
+	b := &buddy{}
+	b.cacheinit(size, ratio, opts...)
+	return b
+}
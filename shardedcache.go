@@ -0,0 +1,152 @@
+// Copyright (c) 2021 Silvano DAL ZILIO
+//
+// MIT License
+
+package rudd
+
+import (
+	"runtime"
+	"sync"
+)
+
+// ************************************************************
+//
+// ShardedCache splits an operator cache into N independently-locked shards,
+// so that concurrent callers (e.g. goroutines spawned for the low/high
+// children of apply/ite/appex) can read and write the cache without
+// contending on a single mutex. A key is routed to its shard using the top
+// bits of its composite hash, keeping shard selection independent from the
+// low bits each shard uses to index its own table. DirectMapCache (and
+// every other Cacher) remains available unsharded for benchmarks or
+// single-threaded use; ShardedCache just wraps N of them.
+
+const (
+	shardHashSpace = 1 << 30
+	shardHashShift = 20
+)
+
+type shardedCacheShard struct {
+	mu    sync.RWMutex
+	cache Cacher
+}
+
+// ShardedCache implements Cacher.
+type ShardedCache struct {
+	ratio  int
+	inner  CachePolicy
+	mask   int
+	shards []shardedCacheShard
+}
+
+// ShardedPolicy is the default CachePolicy for a sharded cache: it splits
+// the table into runtime.NumCPU() shards (rounded up to a power of two),
+// each a DirectMapCache.
+func ShardedPolicy(size, ratio int) Cacher {
+	return newShardedCache(size, ratio, runtime.NumCPU(), DirectMapPolicy)
+}
+
+// ShardedPolicyWith builds a CachePolicy that shards into the given number
+// of shards (rounded up to a power of two), each built with inner. A nil
+// inner defaults to DirectMapPolicy.
+func ShardedPolicyWith(shards int, inner CachePolicy) CachePolicy {
+	return func(size, ratio int) Cacher {
+		return newShardedCache(size, ratio, shards, inner)
+	}
+}
+
+func nextPowerOfTwo(n int) int {
+	p := 1
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
+
+func newShardedCache(size, ratio, shards int, inner CachePolicy) *ShardedCache {
+	if inner == nil {
+		inner = DirectMapPolicy
+	}
+	n := nextPowerOfTwo(shards)
+	c := &ShardedCache{
+		ratio:  ratio,
+		inner:  inner,
+		mask:   n - 1,
+		shards: make([]shardedCacheShard, n),
+	}
+	c.Resize(size)
+	return c
+}
+
+// shardFor picks a shard from the top bits of the key's hash, using
+// whichever formula key.shape calls for (see indexFor), so that shard
+// selection does not correlate with the low bits each shard's own Cacher
+// uses to index its table.
+func (c *ShardedCache) shardFor(key cachekey) int {
+	h := indexFor(key, shardHashSpace)
+	return (h >> shardHashShift) & c.mask
+}
+
+// Get takes the shard's write lock: an inner Cacher's Get may update its own
+// state (LFUCache bumps a frequency counter, CleanDirtyCache promotes a
+// clean hit into its dirty layer).
+func (c *ShardedCache) Get(key cachekey) (int, bool) {
+	s := &c.shards[c.shardFor(key)]
+	s.mu.Lock()
+	res, ok := s.cache.Get(key)
+	s.mu.Unlock()
+	return res, ok
+}
+
+func (c *ShardedCache) Set(key cachekey, res int) {
+	s := &c.shards[c.shardFor(key)]
+	s.mu.Lock()
+	s.cache.Set(key, res)
+	s.mu.Unlock()
+}
+
+func (c *ShardedCache) Reset() {
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.Lock()
+		s.cache.Reset()
+		s.mu.Unlock()
+	}
+}
+
+func (c *ShardedCache) Resize(hint int) {
+	perShard := hint / len(c.shards)
+	if perShard < 1 {
+		perShard = 1
+	}
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.Lock()
+		if s.cache == nil {
+			s.cache = c.inner(perShard, c.ratio)
+		} else {
+			s.cache.Resize(perShard)
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (c *ShardedCache) Len() int {
+	total := 0
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.RLock()
+		total += s.cache.Len()
+		s.mu.RUnlock()
+	}
+	return total
+}
+
+// EntrySize reports the per-slot byte cost of the inner Cacher backing
+// each shard; every shard is built with the same policy, so any one of
+// them is representative.
+func (c *ShardedCache) EntrySize() uintptr {
+	s := &c.shards[0]
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cache.EntrySize()
+}
@@ -0,0 +1,41 @@
+// Copyright (c) 2021 Silvano DAL ZILIO
+//
+// MIT License
+
+package rudd
+
+import (
+	"sync"
+	"testing"
+)
+
+// TestShardedCacheConcurrentAccess exercises concurrent Get/Set from many
+// goroutines; run with -race to check the whole point of ShardedCache, that
+// per-shard locking makes this safe.
+func TestShardedCacheConcurrentAccess(t *testing.T) {
+	c := newShardedCache(64, 0, 8, DirectMapPolicy)
+
+	var wg sync.WaitGroup
+	const goroutines = 16
+	const opsPerGoroutine = 200
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < opsPerGoroutine; i++ {
+				key := cachekey{a: g, b: i, c: g ^ i}
+				c.Set(key, g*opsPerGoroutine+i)
+				c.Get(key)
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func TestShardedCacheEntrySizeMatchesInnerPolicy(t *testing.T) {
+	c := newShardedCache(64, 0, 4, DirectMapPolicy)
+	want := (&DirectMapCache{}).EntrySize()
+	if got := c.EntrySize(); got != want {
+		t.Fatalf("EntrySize() = %d, want %d", got, want)
+	}
+}